@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// combineBinPath is built once in TestMain and reused by every test in this
+// file; buildErr is non-nil if the build itself failed, in which case
+// individual tests skip rather than fail (e.g. no working `go` on PATH).
+var (
+	combineBinPath string
+	buildErr       error
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "combine-bin-*")
+	if err != nil {
+		buildErr = err
+	} else {
+		bin := filepath.Join(dir, "combine")
+		cmd := exec.Command("go", "build", "-o", bin, ".")
+		cmd.Env = append(os.Environ(), "GO111MODULE=off")
+		if out, berr := cmd.CombinedOutput(); berr != nil {
+			buildErr = fmt.Errorf("%w: %s", berr, out)
+		} else {
+			combineBinPath = bin
+		}
+	}
+
+	code := m.Run()
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
+	os.Exit(code)
+}
+
+func runCombine(t *testing.T, args ...string) {
+	t.Helper()
+	if buildErr != nil {
+		t.Skipf("could not build combine binary for integration test: %v", buildErr)
+	}
+	cmd := exec.Command(combineBinPath, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("combine %v failed: %v\n%s", args, err, out)
+	}
+}
+
+func readManifest(t *testing.T, path string) manifest {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading manifest %s: %v", path, err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("parsing manifest %s: %v", path, err)
+	}
+	return m
+}
+
+// TestIncrementalRoundTrip exercises a full modify/add/remove cycle: an
+// -incremental run's output must be byte-identical to a fresh (non-
+// incremental) run over the same final tree, and its manifest must point
+// unchanged files at the bytes that are actually there.
+func TestIncrementalRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "unchanged file a\n")
+	write("b.txt", "file b before modification\n")
+	write("c.txt", "file c, will be removed\n")
+
+	out := filepath.Join(root, "out.txt")
+	manifestPath := out + ".manifest.json"
+
+	// First run: no prior manifest, everything is "new".
+	runCombine(t, "-incremental", "-o", out, src)
+	firstOutput, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstManifest := readManifest(t, manifestPath)
+	if len(firstManifest.Entries) != 3 {
+		t.Fatalf("expected 3 manifest entries after first run, got %d", len(firstManifest.Entries))
+	}
+
+	// Modify b.txt, remove c.txt, add d.txt.
+	write("b.txt", "file b after modification\n")
+	if err := os.Remove(filepath.Join(src, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	write("d.txt", "new file d\n")
+
+	// Second run, incremental: a.txt should be spliced from firstOutput's
+	// bytes rather than re-read/re-transformed.
+	runCombine(t, "-incremental", "-o", out, src)
+	secondOutput, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondManifest := readManifest(t, manifestPath)
+
+	byPath := make(map[string]manifestEntry)
+	for _, e := range secondManifest.Entries {
+		byPath[e.Path] = e
+	}
+	if _, ok := byPath["c.txt"]; ok {
+		t.Error("removed c.txt should not appear in the new manifest")
+	}
+	if _, ok := byPath["d.txt"]; !ok {
+		t.Error("added d.txt should appear in the new manifest")
+	}
+	aEntry, ok := byPath["a.txt"]
+	if !ok {
+		t.Fatal("unchanged a.txt should still appear in the new manifest")
+	}
+	if aEntry.SHA256 != firstManifest.Entries[indexOfPath(firstManifest.Entries, "a.txt")].SHA256 {
+		t.Error("a.txt's sha256 should be unchanged across runs")
+	}
+
+	// The manifest's offset/length must point at exactly a.txt's bytes in
+	// the new output.
+	end := aEntry.Offset + aEntry.Length
+	if end > int64(len(secondOutput)) {
+		t.Fatalf("a.txt's manifest entry [%d,%d) runs past the new output (%d bytes)", aEntry.Offset, end, len(secondOutput))
+	}
+	spliced := secondOutput[aEntry.Offset:end]
+	if string(spliced) != "== a.txt ==\nunchanged file a\n\n" {
+		t.Errorf("a.txt's spliced block = %q, want its original header+body", spliced)
+	}
+
+	// Run fresh (no -incremental) against the same final tree: a clean
+	// combine should produce byte-identical output to the incremental one,
+	// since splicing is only an optimization, not a change in format.
+	freshOut := filepath.Join(root, "fresh.txt")
+	runCombine(t, "-o", freshOut, src)
+	freshOutput, err := os.ReadFile(freshOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(freshOutput) != string(secondOutput) {
+		t.Errorf("incremental output differs from a fresh combine of the same tree:\nincremental=%q\nfresh=%q", secondOutput, freshOutput)
+	}
+
+	if !strings.Contains(string(firstOutput), "file c, will be removed") {
+		t.Error("first run's output should still contain c.txt's content")
+	}
+	if strings.Contains(string(secondOutput), "file c, will be removed") {
+		t.Error("c.txt was removed, so its content should not survive into the second run's output")
+	}
+}
+
+func indexOfPath(entries []manifestEntry, path string) int {
+	for i, e := range entries {
+		if e.Path == path {
+			return i
+		}
+	}
+	return -1
+}
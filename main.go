@@ -1,14 +1,23 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"unicode/utf8"
 )
 
@@ -19,6 +28,11 @@ const (
 	Description = "A utility to recursively combine text files into a single output file"
 )
 
+// combineIgnoreFile is the name of the per-directory ignore file that is
+// automatically discovered while walking the tree, the same way git
+// discovers ".gitignore" files.
+const combineIgnoreFile = ".combineignore"
+
 // isTextFile checks whether a file appears to be a text file.
 // It reads the first 512 bytes and returns false if a null byte is found
 // or if the data is not valid UTF-8.
@@ -49,104 +63,469 @@ func isTextFile(path string) bool {
 
 // DirNode represents a node in the directory tree
 type DirNode struct {
-	Name     string
-	IsDir    bool
-	Children []*DirNode
+	Name     string     `json:"name"`
+	IsDir    bool       `json:"isDir"`
+	Children []*DirNode `json:"children"`
+}
+
+// treeEntry is one path collected by buildDirTree's single enumeration walk,
+// held until the tree-building pass so pattern matching (when a -p pattern
+// is given) can run once over the whole set via parallelMap instead of
+// inline per-file during the walk.
+type treeEntry struct {
+	path string
+	info os.FileInfo
+
+	// isReportSymlink mirrors fileJob.symlinkReportOnly: set for a
+	// "-symlinks report" entry, which must never have its content read
+	// (isTextFile/fileContainsPattern would dereference the link), and so
+	// is exempt from the text-only and pattern filters below rather than
+	// failing them.
+	isReportSymlink bool
+}
+
+// Filters records which filters were applied to a run, for inclusion in the
+// JSON/NDJSON output formats (see -format) alongside the human-readable
+// "Filters applied" text that the default text format prints.
+type Filters struct {
+	IncludeExts     []string `json:"includeExts,omitempty"`
+	ExcludeExts     []string `json:"excludeExts,omitempty"`
+	ExcludePatterns []string `json:"excludePatterns,omitempty"`
+	IncludePatterns []string `json:"includePatterns,omitempty"`
+	Pattern         string   `json:"pattern,omitempty"`
+}
+
+// FileRecord is one file's entry in the JSON/NDJSON output formats.
+type FileRecord struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Mode    string `json:"mode"`
+	ModTime string `json:"modTime"`
+	Content string `json:"content"`
+}
+
+// jsonDocument is the single document written in `-format json` mode.
+type jsonDocument struct {
+	Root    string       `json:"root"`
+	Tree    *DirNode     `json:"tree"`
+	Filters Filters      `json:"filters"`
+	Files   []FileRecord `json:"files"`
+}
+
+// ndjsonHeader is the first line written in `-format ndjson` mode.
+type ndjsonHeader struct {
+	Type    string   `json:"type"`
+	Root    string   `json:"root"`
+	Tree    *DirNode `json:"tree"`
+	Filters Filters  `json:"filters"`
+}
+
+// ndjsonFileRecord is one file line written in `-format ndjson` mode.
+type ndjsonFileRecord struct {
+	Type string `json:"type"`
+	FileRecord
+}
+
+// manifestEntry records where one file's block landed in a `-incremental`
+// run's output, so a later run can either confirm the file is unchanged or
+// splice its prior bytes straight into the new output.
+type manifestEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime"`
+	SHA256  string `json:"sha256"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+}
+
+// manifestSignature captures the run options that change what bytes a file
+// produces in the output. If a later `-incremental` run's signature doesn't
+// match, the old manifest can't be trusted for splicing and is discarded.
+type manifestSignature struct {
+	Pattern    string   `json:"pattern"`
+	Transforms []string `json:"transforms"`
+}
+
+// manifest is the sidecar `<output>.manifest.json` written by `-incremental`
+// runs. It also serves as an index: Offset/Length in each entry point at
+// that file's block inside the combined output.
+type manifest struct {
+	Signature manifestSignature `json:"signature"`
+	Entries   []manifestEntry   `json:"entries"`
+}
+
+// ignorePattern is a single compiled gitignore-style pattern, as found on a
+// line of a `-e`/`-i` flag or a .combineignore file.
+type ignorePattern struct {
+	segments []string // pattern split on "/", with a leading "**" spliced in when not anchored
+	negate   bool     // "!pattern"
+	dirOnly  bool     // trailing "/"
+	anchored bool     // leading "/", or a "/" anywhere but the end
+}
+
+// parseIgnoreLine compiles a single gitignore-style line. It returns ok=false
+// for blank lines and comments, which carry no pattern.
+func parseIgnoreLine(line string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ignorePattern{}, false
+	}
+
+	var p ignorePattern
+	if strings.HasPrefix(trimmed, "!") {
+		p.negate = true
+		trimmed = trimmed[1:]
+	}
+	if strings.HasPrefix(trimmed, "/") {
+		p.anchored = true
+		trimmed = strings.TrimPrefix(trimmed, "/")
+	}
+	if strings.HasSuffix(trimmed, "/") {
+		p.dirOnly = true
+		trimmed = strings.TrimSuffix(trimmed, "/")
+	}
+	if strings.Contains(trimmed, "/") {
+		// A slash anywhere but the trailing position anchors the pattern
+		// to the directory the ignore rule was declared in.
+		p.anchored = true
+	}
+
+	segments := strings.Split(trimmed, "/")
+	if !p.anchored {
+		segments = append([]string{"**"}, segments...)
+	}
+	p.segments = segments
+	return p, true
+}
+
+// doubleStarMatch reports whether nameSegs fully matches patternSegs, where
+// "**" in patternSegs may consume zero or more whole path segments.
+func doubleStarMatch(patternSegs, nameSegs []string) bool {
+	if len(patternSegs) == 0 {
+		return len(nameSegs) == 0
+	}
+	if patternSegs[0] == "**" {
+		if doubleStarMatch(patternSegs[1:], nameSegs) {
+			return true
+		}
+		if len(nameSegs) > 0 && doubleStarMatch(patternSegs, nameSegs[1:]) {
+			return true
+		}
+		return false
+	}
+	if len(nameSegs) == 0 {
+		return false
+	}
+	ok, err := filepath.Match(patternSegs[0], nameSegs[0])
+	if err != nil || !ok {
+		return false
+	}
+	return doubleStarMatch(patternSegs[1:], nameSegs[1:])
+}
+
+// doubleStarPartialMatch reports whether nameSegs, a path that has not been
+// fully matched yet, could still be extended with further segments to match
+// patternSegs. This lets a walker decide whether to descend into a directory
+// such as "foo" when the only active pattern is "foo/bar/*".
+func doubleStarPartialMatch(patternSegs, nameSegs []string) bool {
+	if len(patternSegs) == 0 {
+		return len(nameSegs) == 0
+	}
+	if patternSegs[0] == "**" {
+		if len(nameSegs) == 0 {
+			return true
+		}
+		if doubleStarPartialMatch(patternSegs[1:], nameSegs) {
+			return true
+		}
+		return doubleStarPartialMatch(patternSegs, nameSegs[1:])
+	}
+	if len(nameSegs) == 0 {
+		return true
+	}
+	ok, err := filepath.Match(patternSegs[0], nameSegs[0])
+	if err != nil || !ok {
+		return false
+	}
+	return doubleStarPartialMatch(patternSegs[1:], nameSegs[1:])
+}
+
+// fullMatch checks the pattern against a single path (given as its "/"-split
+// segments), which may be an ancestor directory of the path being tested.
+func (p ignorePattern) fullMatch(segs []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return doubleStarMatch(p.segments, segs)
+}
+
+// partialMatch checks whether segs, a path not yet fully consumed, could
+// still be extended to match the pattern.
+func (p ignorePattern) partialMatch(segs []string) bool {
+	return doubleStarPartialMatch(p.segments, segs)
+}
+
+// patternSet is an ordered list of ignore patterns, matched gitignore-style:
+// the last pattern to match a path wins, so a later "!pattern" can
+// re-include something an earlier pattern excluded.
+type patternSet struct {
+	patterns []ignorePattern
+}
+
+// newPatternSet compiles raw pattern lines (blanks/comments are skipped).
+func newPatternSet(raws []string) *patternSet {
+	ps := &patternSet{}
+	for _, r := range raws {
+		if pat, ok := parseIgnoreLine(r); ok {
+			ps.patterns = append(ps.patterns, pat)
+		}
+	}
+	return ps
+}
+
+func (ps *patternSet) empty() bool {
+	return ps == nil || len(ps.patterns) == 0
+}
+
+// clone returns a copy of ps so a child directory can extend it without
+// mutating the parent's pattern set.
+func (ps *patternSet) clone() *patternSet {
+	if ps == nil {
+		return &patternSet{}
+	}
+	cp := make([]ignorePattern, len(ps.patterns))
+	copy(cp, ps.patterns)
+	return &patternSet{patterns: cp}
+}
+
+// extend returns a new patternSet with raws compiled and appended after ps's
+// existing patterns, so the new rules take precedence as gitignore semantics
+// require.
+func (ps *patternSet) extend(raws []string) *patternSet {
+	n := ps.clone()
+	for _, r := range raws {
+		if pat, ok := parseIgnoreLine(r); ok {
+			n.patterns = append(n.patterns, pat)
+		}
+	}
+	return n
+}
+
+// match reports whether relPath (using "/" separators) is matched, applying
+// patterns in order so later patterns (including negations) override
+// earlier ones. It also checks every ancestor directory of relPath, so that
+// a directory-excluding pattern excludes everything beneath it.
+func (ps *patternSet) match(relPath string, isDir bool) bool {
+	if ps.empty() || relPath == "." || relPath == "" {
+		return false
+	}
+	segs := strings.Split(filepath.ToSlash(relPath), "/")
+	matched := false
+	for _, p := range ps.patterns {
+		for i := 1; i <= len(segs); i++ {
+			prefixIsDir := i < len(segs) || isDir
+			if p.fullMatch(segs[:i], prefixIsDir) {
+				matched = !p.negate
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// matchPartial reports whether relPath, a directory that itself does not
+// fully match, could contain descendants that match one of the (positive)
+// patterns in the set. Used to avoid pruning a directory like "foo" out of
+// a walk when an include pattern is "foo/bar/*".
+func (ps *patternSet) matchPartial(relPath string) bool {
+	if ps.empty() {
+		return false
+	}
+	if relPath == "." || relPath == "" {
+		return true
+	}
+	segs := strings.Split(filepath.ToSlash(relPath), "/")
+	for _, p := range ps.patterns {
+		if p.negate {
+			continue
+		}
+		if p.partialMatch(segs) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCombineIgnoreLines reads the .combineignore file in dir, if any.
+func loadCombineIgnoreLines(dir string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, combineIgnoreFile))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// excludeSetForDir returns the exclude patternSet that applies to the
+// children of dir: the patterns inherited from its ancestors (rooted at
+// base, the set built from -e) plus dir's own .combineignore, if present.
+// Results are memoized in cache so each directory's .combineignore is read
+// at most once per walk.
+func excludeSetForDir(cache map[string]*patternSet, rootDir, dir string, base *patternSet) *patternSet {
+	if set, ok := cache[dir]; ok {
+		return set
+	}
+	var parent *patternSet
+	if dir == rootDir {
+		parent = base
+	} else {
+		parent = excludeSetForDir(cache, rootDir, filepath.Dir(dir), base)
+	}
+	set := parent.extend(loadCombineIgnoreLines(dir))
+	cache[dir] = set
+	return set
+}
+
+// pathDecision bundles the outcome of matching a path against the active
+// exclude/include pattern sets.
+type pathDecision struct {
+	included bool // should this path be processed
+	descend  bool // if it's a directory, should the walk recurse into it
+}
+
+// decidePath evaluates relPath against the exclude set active in its parent
+// directory (looked up/memoized via excludeCache) and the (flat,
+// non-per-directory) include set.
+func decidePath(excludeCache map[string]*patternSet, rootDir string, baseExclude *patternSet, include *patternSet, path, relPath string, isDir bool) pathDecision {
+	parentDir := filepath.Dir(path)
+	parentExclude := excludeSetForDir(excludeCache, rootDir, parentDir, baseExclude)
+
+	excluded := parentExclude.match(relPath, isDir)
+	includeApplies := !include.empty()
+	included := !includeApplies || include.match(relPath, isDir)
+
+	decision := pathDecision{included: included && !excluded}
+	if isDir {
+		decision.descend = !excluded && (!includeApplies || include.match(relPath, true) || include.matchPartial(relPath))
+	}
+	return decision
+}
+
+// symlinkAction is how a walk callback should handle a symlink entry, as
+// decided by resolveSymlink from the configured -symlinks policy.
+type symlinkAction struct {
+	proceed     bool   // continue with normal per-path handling using the original symlink path/info
+	descendInto string // "follow" mode only: resolved directory to walk in place of this entry
+}
+
+// inodeKey returns a "dev:ino" identity for info, used to detect symlink
+// cycles in "follow" mode. ok is false if the platform doesn't expose
+// syscall.Stat_t, in which case cycle detection is skipped.
+func inodeKey(info os.FileInfo) (string, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino), true
+}
+
+// isWithinRoot reports whether target is rootAbs itself or lives beneath it.
+func isWithinRoot(rootAbs, target string) bool {
+	rel, err := filepath.Rel(rootAbs, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || !strings.HasPrefix(rel, "..")
+}
+
+// resolveSymlink decides what a walk should do when it encounters a symlink
+// at path, given the -symlinks mode ("skip", "follow", or "report") and
+// whether -symlinks-external allows following links that resolve outside
+// rootAbs. visited tracks inode keys already followed in this walk, so
+// "follow" mode can break symlink cycles.
+func resolveSymlink(rootAbs, path, mode string, allowExternal bool, visited map[string]bool) symlinkAction {
+	switch mode {
+	case "follow":
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return symlinkAction{}
+		}
+		resolvedAbs, err := filepath.Abs(resolved)
+		if err != nil {
+			return symlinkAction{}
+		}
+		if !allowExternal && !isWithinRoot(rootAbs, resolvedAbs) {
+			return symlinkAction{}
+		}
+		targetInfo, err := os.Stat(resolvedAbs)
+		if err != nil {
+			return symlinkAction{}
+		}
+		if key, ok := inodeKey(targetInfo); ok {
+			if visited[key] {
+				return symlinkAction{}
+			}
+			visited[key] = true
+		}
+		if targetInfo.IsDir() {
+			return symlinkAction{descendInto: resolvedAbs}
+		}
+		// The target is a regular file: os.Open/ReadFile on the original
+		// symlink path already follows it at the syscall level, so just
+		// proceed with the normal per-path handling below.
+		return symlinkAction{proceed: true}
+	case "report":
+		// List the symlink itself (using its own Lstat info) without
+		// following it. Content is never read through a "report" path: the
+		// main walk marks these jobs symlinkReportOnly so processFileJob
+		// lists the link's target (os.Readlink) instead of opening it,
+		// which would otherwise transparently dereference the link.
+		return symlinkAction{proceed: true}
+	default: // "skip"
+		return symlinkAction{}
+	}
 }
 
 // buildDirTree builds a directory tree structure
-func buildDirTree(rootPath string, outFilePath string, isTextOnly bool, includeExts, excludeExts, excludePaths []string, textPattern string) (*DirNode, error) {
+func buildDirTree(rootPath string, outFilePath string, isTextOnly bool, includeExts, excludeExts []string, excludePatterns, includePatterns []string, textPattern string, workers int, symlinksMode string, symlinksExternal bool) (*DirNode, error) {
 	outAbs, err := filepath.Abs(outFilePath)
 	if err != nil {
 		return nil, err
 	}
+	rootAbs, err := filepath.Abs(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	baseExclude := newPatternSet(excludePatterns)
+	include := newPatternSet(includePatterns)
 
 	rootNode := &DirNode{
 		Name:     filepath.Base(rootPath),
 		IsDir:    true,
 		Children: []*DirNode{},
 	}
-	
+
 	nodesMap := make(map[string]*DirNode)
 	nodesMap[rootPath] = rootNode
 
-	// The first pass is only to identify files matching the pattern, if a pattern is specified
-	var patternMatchedFiles map[string]bool
-	if textPattern != "" {
-		patternMatchedFiles = make(map[string]bool)
-		
-		// First walk to identify files with the pattern
-		err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			
-			// Skip directories and output file
-			if info.IsDir() {
-				// Check for excluded directories
-				relPath, err := filepath.Rel(rootPath, path)
-				if err != nil {
-					relPath = path
-				}
-				
-				if isExcludedPath(relPath, excludePaths) {
-					return filepath.SkipDir
-				}
-				
-				return nil
-			}
-			
-			// Skip the output file
-			pathAbs, err := filepath.Abs(path)
-			if err != nil {
-				return err
-			}
-			if pathAbs == outAbs {
-				return nil
-			}
-			
-			// Get relative path for exclusion check
-			relPath, err := filepath.Rel(rootPath, path)
-			if err != nil {
-				relPath = path
-			}
-			
-			// Skip excluded files
-			if isExcludedPath(relPath, excludePaths) {
-				return nil
-			}
-			
-			// Apply extension filters
-			if !info.IsDir() {
-				ext := strings.TrimPrefix(filepath.Ext(path), ".")
-				if len(includeExts) > 0 && !containsExt(includeExts, ext) {
-					return nil
-				}
-				if len(excludeExts) > 0 && containsExt(excludeExts, ext) {
-					return nil
-				}
-			}
-			
-			// Only process text files if required
-			if isTextOnly && !isTextFile(path) {
-				return nil
-			}
-			
-			// Check for pattern match
-			if fileContainsPattern(path, textPattern) {
-				patternMatchedFiles[path] = true
-			}
-			
-			return nil
-		})
-		
-		if err != nil {
-			return nil, err
-		}
-	}
+	// A single walk enumerates every path eligible for the tree (directories
+	// that are included or merely descended into, and files passing the
+	// include/exclude, extension, and text-only filters) in depth-first
+	// pre-order, so a path's parent is always enumerated before it. Pattern
+	// matching, when a -p pattern is given, is deferred until after this walk:
+	// it re-reads every candidate file and runs on the shared worker pool via
+	// parallelMap, so it's done once over the collected list rather than
+	// inline per-file during enumeration.
+	excludeCache := make(map[string]*patternSet)
+	visited := make(map[string]bool)
+
+	var entries []treeEntry
+	var candidates []string
 
-	// Second pass to build the tree, only with files that contain the pattern
-	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	var visit filepath.WalkFunc
+	visit = func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -164,61 +543,110 @@ func buildDirTree(rootPath string, outFilePath string, isTextOnly bool, includeE
 		if path == rootPath {
 			return nil
 		}
-		
-		// Skip excluded paths
+
+		isReportSymlink := false
+		if info.Mode()&os.ModeSymlink != 0 {
+			action := resolveSymlink(rootAbs, path, symlinksMode, symlinksExternal, visited)
+			if action.descendInto != "" {
+				return filepath.Walk(action.descendInto, func(subPath string, subInfo os.FileInfo, subErr error) error {
+					rel, relErr := filepath.Rel(action.descendInto, subPath)
+					mapped := path
+					if relErr == nil && rel != "." {
+						mapped = filepath.Join(path, rel)
+					}
+					return visit(mapped, subInfo, subErr)
+				})
+			}
+			if !action.proceed {
+				return nil
+			}
+			isReportSymlink = symlinksMode == "report"
+		}
+
 		relPath, err := filepath.Rel(rootPath, path)
 		if err != nil {
 			relPath = path
 		}
-		
-		if isExcludedPath(relPath, excludePaths) {
-			if info.IsDir() {
+
+		decision := decidePath(excludeCache, rootPath, baseExclude, include, path, relPath, info.IsDir())
+		if info.IsDir() {
+			// A directory that's merely descended into (an ancestor of an
+			// include match, e.g. "src" for "-i src/**/*.go") still needs a
+			// node so matching files under it can find their parent below;
+			// decision.included only reflects whether the directory itself
+			// matched a pattern.
+			if !decision.descend {
 				return filepath.SkipDir
 			}
+			entries = append(entries, treeEntry{path: path, info: info})
 			return nil
 		}
-		
-		// For files, check if they match the pattern (if pattern is specified)
-		if !info.IsDir() && textPattern != "" && !patternMatchedFiles[path] {
+
+		if !decision.included {
 			return nil
 		}
-		
+
 		// Skip files that don't match extension filters
-		if !info.IsDir() {
-			ext := strings.TrimPrefix(filepath.Ext(path), ".")
-			if len(includeExts) > 0 && !containsExt(includeExts, ext) {
-				return nil
-			}
-			if len(excludeExts) > 0 && containsExt(excludeExts, ext) {
-				return nil
-			}
+		ext := strings.TrimPrefix(filepath.Ext(path), ".")
+		if len(includeExts) > 0 && !containsExt(includeExts, ext) {
+			return nil
+		}
+		if len(excludeExts) > 0 && containsExt(excludeExts, ext) {
+			return nil
 		}
-		
-		// Skip non-text files if isTextOnly is true
-		if !info.IsDir() && isTextOnly && !isTextFile(path) {
+
+		// Skip non-text files if isTextOnly is true. A "-symlinks report"
+		// entry is exempt: isTextFile would open the link path, which the OS
+		// transparently dereferences, reading through to the target's
+		// content exactly like the bug fixed for processFileJob in bdb2168.
+		if isTextOnly && !isReportSymlink && !isTextFile(path) {
 			return nil
 		}
 
-		// Add directory nodes even if no files match, to maintain directory structure
-		parentPath := filepath.Dir(path)
+		if textPattern != "" && !isReportSymlink {
+			candidates = append(candidates, path)
+		}
+		entries = append(entries, treeEntry{path: path, info: info, isReportSymlink: isReportSymlink})
+		return nil
+	}
+
+	if err := filepath.Walk(rootPath, visit); err != nil {
+		return nil, err
+	}
+
+	var patternMatchedFiles map[string]bool
+	if textPattern != "" {
+		patternMatchedFiles = parallelMap(candidates, resolveWorkerCount(workers), func(path string) bool {
+			return fileContainsPattern(path, textPattern)
+		})
+	}
+
+	// Build the tree from the entries collected above; no further walking of
+	// the filesystem is needed. A report-mode symlink is never pattern-
+	// filtered (its content is never read), so it's kept unconditionally,
+	// same as processFileJob's symlinkReportOnly path.
+	for _, entry := range entries {
+		if !entry.info.IsDir() && textPattern != "" && !entry.isReportSymlink && !patternMatchedFiles[entry.path] {
+			continue
+		}
+
+		parentPath := filepath.Dir(entry.path)
 		parentNode, exists := nodesMap[parentPath]
 		if !exists {
-			return fmt.Errorf("parent node not found for %s", path)
+			return nil, fmt.Errorf("parent node not found for %s", entry.path)
 		}
 
 		node := &DirNode{
-			Name:     filepath.Base(path),
-			IsDir:    info.IsDir(),
+			Name:     filepath.Base(entry.path),
+			IsDir:    entry.info.IsDir(),
 			Children: []*DirNode{},
 		}
-		
+
 		parentNode.Children = append(parentNode.Children, node)
-		if info.IsDir() {
-			nodesMap[path] = node
+		if entry.info.IsDir() {
+			nodesMap[entry.path] = node
 		}
-		
-		return nil
-	})
+	}
 
 	// Sort children alphabetically with directories first
 	for _, node := range nodesMap {
@@ -230,7 +658,7 @@ func buildDirTree(rootPath string, outFilePath string, isTextOnly bool, includeE
 		})
 	}
 
-	return rootNode, err
+	return rootNode, nil
 }
 
 // containsExt checks if an extension is in the given list
@@ -245,44 +673,68 @@ func containsExt(exts []string, ext string) bool {
 }
 
 // getFormatStats returns statistics about file formats in the directory
-func getFormatStats(rootPath string, includeExts, excludeExts, excludePaths []string, textPattern string) (map[string]int, error) {
+func getFormatStats(rootPath string, includeExts, excludeExts []string, excludePatterns, includePatterns []string, textPattern string, symlinksMode string, symlinksExternal bool) (map[string]int, error) {
 	stats := make(map[string]int)
-	
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+
+	baseExclude := newPatternSet(excludePatterns)
+	include := newPatternSet(includePatterns)
+	excludeCache := make(map[string]*patternSet)
+	visited := make(map[string]bool)
+
+	rootAbs, err := filepath.Abs(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var visit filepath.WalkFunc
+	visit = func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		
-		// Skip directories, but check if they should be excluded first
-		if info.IsDir() {
-			// Get relative path for exclusion check
-			relPath, err := filepath.Rel(rootPath, path)
-			if err != nil {
-				relPath = path
+
+		isReportSymlink := false
+		if info.Mode()&os.ModeSymlink != 0 {
+			action := resolveSymlink(rootAbs, path, symlinksMode, symlinksExternal, visited)
+			if action.descendInto != "" {
+				return filepath.Walk(action.descendInto, func(subPath string, subInfo os.FileInfo, subErr error) error {
+					rel, relErr := filepath.Rel(action.descendInto, subPath)
+					mapped := path
+					if relErr == nil && rel != "." {
+						mapped = filepath.Join(path, rel)
+					}
+					return visit(mapped, subInfo, subErr)
+				})
 			}
-			
-			// Skip excluded directories
-			if isExcludedPath(relPath, excludePaths) {
-				return filepath.SkipDir
+			if !action.proceed {
+				return nil
 			}
-			
-			return nil
+			isReportSymlink = symlinksMode == "report"
 		}
-		
-		// Get relative path for exclusion check
+
 		relPath, err := filepath.Rel(rootPath, path)
 		if err != nil {
 			relPath = path
 		}
-		
-		// Skip excluded files
-		if isExcludedPath(relPath, excludePaths) {
+
+		if info.IsDir() {
+			if path == rootPath {
+				return nil
+			}
+			decision := decidePath(excludeCache, rootPath, baseExclude, include, path, relPath, true)
+			if !decision.descend {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		decision := decidePath(excludeCache, rootPath, baseExclude, include, path, relPath, false)
+		if !decision.included {
 			return nil
 		}
-		
+
 		// Get file extension (without the dot)
 		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
-		
+
 		// Apply extension filters
 		if len(includeExts) > 0 && !containsExt(includeExts, ext) {
 			return nil
@@ -290,109 +742,558 @@ func getFormatStats(rootPath string, includeExts, excludeExts, excludePaths []st
 		if len(excludeExts) > 0 && containsExt(excludeExts, ext) {
 			return nil
 		}
-		
-		// Check if the file contains the pattern if specified
-		if textPattern != "" && !fileContainsPattern(path, textPattern) {
+
+		// Check if the file contains the pattern if specified. A
+		// "-symlinks report" entry never has fileContainsPattern run on its
+		// link path: that would open and read through the symlink (the OS
+		// dereferences it transparently), exactly the content-disclosure
+		// hole -symlinks-external is supposed to gate. It's counted
+		// unconditionally instead, same as processFileJob's symlinkReportOnly
+		// path.
+		if textPattern != "" && !isReportSymlink && !fileContainsPattern(path, textPattern) {
 			return nil
 		}
-		
+
 		// Use "no-extension" for files without extension
 		if ext == "" {
 			ext = "no-extension"
 		}
-		
-		stats[ext]++
-		return nil
-	})
-	
-	return stats, err
+
+		stats[ext]++
+		return nil
+	}
+
+	err = filepath.Walk(rootPath, visit)
+
+	return stats, err
+}
+
+// printTreeToString renders the tree structure to a string
+func printTreeToString(node *DirNode, prefix string, isLast bool, result *strings.Builder) {
+	if node.Name == "." || node.Name == "" {
+		result.WriteString("Directory Structure:\n")
+	} else {
+		// Print current node
+		entry := prefix
+		if isLast {
+			entry += "└── "
+			prefix += "    "
+		} else {
+			entry += "├── "
+			prefix += "│   "
+		}
+
+		result.WriteString(entry + node.Name)
+		if node.IsDir {
+			result.WriteString("/")
+		}
+		result.WriteString("\n")
+	}
+
+	// Print children
+	for i, child := range node.Children {
+		isLastChild := i == len(node.Children)-1
+		printTreeToString(child, prefix, isLastChild, result)
+	}
+}
+
+// fileContainsPattern checks if a file contains the specified text pattern
+func fileContainsPattern(path, pattern string) bool {
+	if pattern == "" {
+		return true // Always match if no pattern is specified
+	}
+
+	// Read file content
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	// Convert to string and check if pattern exists
+	contentStr := string(content)
+	return strings.Contains(contentStr, pattern)
+}
+
+// resolveWorkerCount turns a `-j` value into an actual worker count, with 0
+// meaning "use all available CPUs".
+func resolveWorkerCount(j int) int {
+	if j > 0 {
+		return j
+	}
+	return runtime.NumCPU()
+}
+
+// parallelMap runs fn over paths using a pool of workers, returning the
+// subset of paths for which fn returned true. It's the worker pool shared by
+// buildDirTree's pattern pre-pass and the main combining pipeline, so
+// I/O-heavy per-file checks (like fileContainsPattern) don't run serially.
+func parallelMap(paths []string, workers int, fn func(path string) bool) map[string]bool {
+	jobs := make(chan string)
+	type outcome struct {
+		path string
+		ok   bool
+	}
+	results := make(chan outcome)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				results <- outcome{path: p, ok: fn(p)}
+			}
+		}()
+	}
+	go func() {
+		for _, p := range paths {
+			jobs <- p
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	matched := make(map[string]bool)
+	for r := range results {
+		if r.ok {
+			matched[r.path] = true
+		}
+	}
+	return matched
+}
+
+// compactContent collapses content to a single line, trimming each line and
+// encoding its indentation depth as leading spaces. This is the "compact"
+// transform applied to file bodies unless -nocompact is given.
+func compactContent(content []byte) string {
+	contentStr := string(content)
+
+	// Normalize line endings
+	contentStr = strings.ReplaceAll(contentStr, "\r\n", "\n")
+	contentStr = strings.ReplaceAll(contentStr, "\r", "\n")
+
+	// Process each line and add an indicator of indentation level
+	lines := strings.Split(contentStr, "\n")
+	var compressed strings.Builder
+
+	for _, line := range lines {
+		// Count leading whitespace to preserve indentation info
+		indent := 0
+		for _, c := range line {
+			if c == ' ' {
+				indent++
+			} else if c == '\t' {
+				indent += 4 // Treat tab as 4 spaces
+			} else {
+				break
+			}
+		}
+
+		// Trim the line
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" {
+			continue // Skip empty lines
+		}
+
+		// Add a separator between lines, but not before the first line
+		if compressed.Len() > 0 {
+			compressed.WriteString(" ")
+		}
+
+		// Add indentation spaces for readability, without special symbols
+		if indent > 0 {
+			// Use a space followed by additional spaces for each level of indentation
+			compressed.WriteString(strings.Repeat(" ", 1+(indent/4)))
+		}
+
+		// Add the line content
+		compressed.WriteString(trimmedLine)
+	}
+
+	return compressed.String()
+}
+
+// Transform is one stage of the `-transform` pipeline: given a file's path
+// (for language detection) and content, it returns the transformed content.
+type Transform func(path string, content []byte) ([]byte, error)
+
+// Transforms is the registry of built-in transforms available to
+// `-transform`. Names given to `-transform` are looked up here and run in
+// the order the user listed them, e.g. `-transform strip-comments,compact`
+// strips comments first, then compacts what's left to a single line.
+var Transforms = map[string]Transform{
+	"compact":        compactTransform,
+	"strip-comments": stripCommentsTransform,
+	"redact-secrets": redactSecretsTransform,
+	"dedent":         dedentTransform,
+	"normalize-eol":  normalizeEOLTransform,
+}
+
+// transformNames returns the registered transform names, sorted, for use in
+// usage and error messages.
+func transformNames() []string {
+	names := make([]string, 0, len(Transforms))
+	for name := range Transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyTransforms runs the named transforms over content in order, feeding
+// each transform's output into the next.
+func applyTransforms(path string, content []byte, names []string) ([]byte, error) {
+	for _, name := range names {
+		fn, ok := Transforms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q", name)
+		}
+		var err error
+		content, err = fn(path, content)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", name, err)
+		}
+	}
+	return content, nil
+}
+
+// compactTransform is compactContent adapted to the Transform signature.
+func compactTransform(_ string, content []byte) ([]byte, error) {
+	return []byte(compactContent(content)), nil
+}
+
+// normalizeEOLTransform rewrites CRLF and bare CR line endings to LF.
+func normalizeEOLTransform(_ string, content []byte) ([]byte, error) {
+	s := strings.ReplaceAll(string(content), "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return []byte(s), nil
+}
+
+// dedentTransform strips the longest run of leading whitespace shared by
+// every non-blank line, preserving relative indentation.
+func dedentTransform(_ string, content []byte) ([]byte, error) {
+	lines := strings.Split(string(content), "\n")
+
+	minIndent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if minIndent == -1 || indent < minIndent {
+			minIndent = indent
+		}
+	}
+	if minIndent <= 0 {
+		return content, nil
+	}
+
+	for i, line := range lines {
+		if len(line) >= minIndent {
+			lines[i] = line[minIndent:]
+		} else {
+			lines[i] = strings.TrimLeft(line, " \t")
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// stripCommentsTransform removes comments for a handful of common
+// languages, selected by file extension. Files in languages we don't
+// recognize pass through unchanged.
+func stripCommentsTransform(path string, content []byte) ([]byte, error) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "go", "c", "h", "js", "jsx", "ts", "tsx":
+		return stripCStyleComments(content), nil
+	case "py", "sh":
+		return stripHashStyleComments(content), nil
+	default:
+		return content, nil
+	}
+}
+
+// stripCStyleComments is a simple tokenizer for "//" and "/* */" comments
+// used by go/c/h/js/ts. It tracks quoted strings (including Go backtick
+// raw strings) well enough not to treat comment-like text inside them as a
+// comment, but it isn't a full language parser.
+func stripCStyleComments(content []byte) []byte {
+	s := string(content)
+	n := len(s)
+	var out strings.Builder
+
+	for i := 0; i < n; {
+		c := s[i]
+		switch {
+		case c == '/' && i+1 < n && s[i+1] == '/':
+			for i < n && s[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < n && s[i+1] == '*':
+			i += 2
+			for i+1 < n && !(s[i] == '*' && s[i+1] == '/') {
+				if s[i] == '\n' {
+					out.WriteByte('\n')
+				}
+				i++
+			}
+			i += 2
+		case c == '"' || c == '\'' || c == '`':
+			quote := c
+			out.WriteByte(c)
+			i++
+			for i < n && s[i] != quote {
+				if s[i] == '\\' && quote != '`' && i+1 < n {
+					out.WriteByte(s[i])
+					i++
+				}
+				if i < n {
+					out.WriteByte(s[i])
+					i++
+				}
+			}
+			if i < n {
+				out.WriteByte(s[i])
+				i++
+			}
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return []byte(out.String())
+}
+
+// stripHashStyleComments is a simple tokenizer for "#" comments used by
+// py/sh, including shebang lines. It tracks single- and double-quoted
+// strings so a '#' inside one isn't treated as a comment.
+func stripHashStyleComments(content []byte) []byte {
+	s := string(content)
+	n := len(s)
+	var out strings.Builder
+
+	for i := 0; i < n; {
+		c := s[i]
+		switch {
+		case c == '#':
+			for i < n && s[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			quote := c
+			out.WriteByte(c)
+			i++
+			for i < n && s[i] != quote {
+				if s[i] == '\\' && i+1 < n {
+					out.WriteByte(s[i])
+					i++
+				}
+				if i < n {
+					out.WriteByte(s[i])
+					i++
+				}
+			}
+			if i < n {
+				out.WriteByte(s[i])
+				i++
+			}
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return []byte(out.String())
 }
 
-// printTreeToString renders the tree structure to a string
-func printTreeToString(node *DirNode, prefix string, isLast bool, result *strings.Builder) {
-	if node.Name == "." || node.Name == "" {
-		result.WriteString("Directory Structure:\n")
-	} else {
-		// Print current node
-		entry := prefix
-		if isLast {
-			entry += "└── "
-			prefix += "    "
-		} else {
-			entry += "├── "
-			prefix += "│   "
-		}
-		
-		result.WriteString(entry + node.Name)
-		if node.IsDir {
-			result.WriteString("/")
-		}
-		result.WriteString("\n")
+// Regexes backing redactSecretsTransform. pemPrivateKeyPattern matches a
+// whole "-----BEGIN ... PRIVATE KEY-----" block; the others match a single
+// token.
+var (
+	pemPrivateKeyPattern      = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+	jwtPattern                = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\b`)
+	awsAccessKeyIDPattern     = regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`)
+	passwordAssignmentPattern = regexp.MustCompile(`(?i)\b(password|passwd|pwd)(\s*[:=]\s*)\S+`)
+)
+
+// redactSecretsTransform replaces common secret shapes (AWS access key IDs,
+// JWTs, PEM private key blocks, and `password=...`-style assignments) with
+// "[REDACTED]" so they don't end up in combined output.
+func redactSecretsTransform(_ string, content []byte) ([]byte, error) {
+	s := string(content)
+	s = pemPrivateKeyPattern.ReplaceAllString(s, "[REDACTED]")
+	s = jwtPattern.ReplaceAllString(s, "[REDACTED]")
+	s = awsAccessKeyIDPattern.ReplaceAllString(s, "[REDACTED]")
+	s = passwordAssignmentPattern.ReplaceAllString(s, "${1}${2}[REDACTED]")
+	return []byte(s), nil
+}
+
+// fileJob is a candidate file handed from the walker goroutine to a worker.
+// seq is assigned in walk order so the writer can restore that order even
+// though workers finish out of sequence.
+type fileJob struct {
+	seq     int
+	path    string
+	relPath string
+	info    os.FileInfo
+
+	// symlinkReportOnly is set when this entry is a symlink under
+	// "-symlinks report": processFileJob must list the link's target
+	// without ever opening/reading through the symlink path, since the OS
+	// would transparently dereference it (see resolveSymlink's "report"
+	// case).
+	symlinkReportOnly bool
+}
+
+// fileResult is what a worker hands back to the writer goroutine for a
+// given fileJob. header/body are used by the "text" output format; the
+// remaining fields back the "json"/"ndjson" formats.
+type fileResult struct {
+	seq     int
+	relPath string
+	header  string
+	body    []byte
+	size    int64
+	sha256  string
+	mode    string
+	modTime string
+	content string
+	skip    bool
+	err     error
+	spliced []byte // -incremental only: prior output bytes to reuse verbatim for an unchanged file
+}
+
+// processFileJob does the I/O-heavy per-file work (text detection, pattern
+// search, read, transform pipeline) that used to run inline in the
+// sequential walk. When format isn't "text" and b64 is set, the text-only
+// filter is relaxed so binary files can round-trip through base64-encoded
+// content, bypassing transforms (which assume text).
+//
+// When incremental is set, the file is still read and hashed (size/mtime
+// alone aren't a safe enough signal: either can be unchanged while content
+// isn't), but if its sha256 matches oldManifest's recorded hash for
+// job.relPath, the transform pipeline is skipped and the result instead
+// carries the prior output bytes at that entry's [offset, offset+length),
+// sliced from oldOutputBytes, for the writer to splice in as-is.
+func processFileJob(job fileJob, pattern string, transforms []string, format string, b64 bool, incremental bool, oldManifest map[string]manifestEntry, oldOutputBytes []byte) fileResult {
+	if job.symlinkReportOnly {
+		return reportSymlinkResult(job, format, b64)
 	}
 
-	// Print children
-	for i, child := range node.Children {
-		isLastChild := i == len(node.Children)-1
-		printTreeToString(child, prefix, isLastChild, result)
+	relaxTextFilter := b64 && format != "text"
+	if !relaxTextFilter && !isTextFile(job.path) {
+		return fileResult{seq: job.seq, skip: true}
 	}
-}
 
-// isExcludedPath checks if a path matches any of the excluded paths
-func isExcludedPath(path string, excludedPaths []string) bool {
-	if len(excludedPaths) == 0 {
-		return false
+	if pattern != "" && !fileContainsPattern(job.path, pattern) {
+		return fileResult{seq: job.seq, skip: true}
 	}
 
-	// Normalize path separators for consistent matching
-	normalizedPath := filepath.ToSlash(path)
-	
-	for _, excludedPath := range excludedPaths {
-		// Normalize excluded path
-		normalizedExcludedPath := filepath.ToSlash(excludedPath)
-		
-		// Check for exact match
-		if normalizedPath == normalizedExcludedPath {
-			return true
+	content, err := ioutil.ReadFile(job.path)
+	if err != nil {
+		return fileResult{seq: job.seq, err: err}
+	}
+
+	sum := sha256.Sum256(content)
+	sha := hex.EncodeToString(sum[:])
+
+	if incremental {
+		if prior, ok := oldManifest[job.relPath]; ok {
+			end := prior.Offset + prior.Length
+			if prior.SHA256 == sha && end <= int64(len(oldOutputBytes)) {
+				return fileResult{
+					seq:     job.seq,
+					relPath: job.relPath,
+					size:    job.info.Size(),
+					sha256:  sha,
+					mode:    job.info.Mode().String(),
+					modTime: job.info.ModTime().UTC().Format(time.RFC3339),
+					spliced: oldOutputBytes[prior.Offset:end],
+				}
+			}
 		}
-		
-		// Check if this is a directory prefix match
-		// e.g. "node_modules" should match "node_modules/anything"
-		if strings.HasPrefix(normalizedPath, normalizedExcludedPath+"/") {
-			return true
+	}
+
+	result := fileResult{
+		seq:     job.seq,
+		relPath: job.relPath,
+		size:    job.info.Size(),
+		mode:    job.info.Mode().String(),
+		modTime: job.info.ModTime().UTC().Format(time.RFC3339),
+		sha256:  sha,
+	}
+
+	switch {
+	case format == "text":
+		transformed, err := applyTransforms(job.path, content, transforms)
+		if err != nil {
+			return fileResult{seq: job.seq, err: err}
 		}
-		
-		// Check for path matching with glob patterns
-		matched, err := filepath.Match(normalizedExcludedPath, normalizedPath)
-		if err == nil && matched {
-			return true
+		result.body = transformed
+		result.header = fmt.Sprintf("== %s ==\n", job.relPath)
+	case b64:
+		result.content = base64.StdEncoding.EncodeToString(content)
+	default:
+		transformed, err := applyTransforms(job.path, content, transforms)
+		if err != nil {
+			return fileResult{seq: job.seq, err: err}
+		}
+		result.content = string(transformed)
+		// json/ndjson "content" is the transformed text, so size/sha256
+		// must describe those same bytes or the record can't be used to
+		// verify integrity (the raw-file sha256 is still what gates
+		// -incremental above, via the untransformed `content`/`sha`).
+		if len(transforms) > 0 {
+			result.size = int64(len(transformed))
+			transformedSum := sha256.Sum256(transformed)
+			result.sha256 = hex.EncodeToString(transformedSum[:])
 		}
 	}
-	
-	return false
+
+	return result
 }
 
-// fileContainsPattern checks if a file contains the specified text pattern
-func fileContainsPattern(path, pattern string) bool {
-	if pattern == "" {
-		return true // Always match if no pattern is specified
+// reportSymlinkResult builds the fileResult for a "-symlinks report" entry.
+// It uses os.Readlink, which reads the link itself rather than the file it
+// points to, so a symlink into an untrusted tree (e.g. a crafted archive
+// pointing at /etc/passwd) never has its target's content opened or
+// embedded in the output, regardless of -symlinks-external.
+func reportSymlinkResult(job fileJob, format string, b64 bool) fileResult {
+	target, err := os.Readlink(job.path)
+	if err != nil {
+		target = ""
 	}
 
-	// Read file content
-	content, err := ioutil.ReadFile(path)
-	if err != nil {
-		return false
+	sum := sha256.Sum256([]byte(target))
+	result := fileResult{
+		seq:     job.seq,
+		relPath: job.relPath,
+		size:    job.info.Size(),
+		mode:    job.info.Mode().String(),
+		modTime: job.info.ModTime().UTC().Format(time.RFC3339),
+		sha256:  hex.EncodeToString(sum[:]),
 	}
 
-	// Convert to string and check if pattern exists
-	contentStr := string(content)
-	return strings.Contains(contentStr, pattern)
+	switch {
+	case format == "text":
+		result.body = []byte(fmt.Sprintf("-> %s\n", target))
+		result.header = fmt.Sprintf("== %s (symlink) ==\n", job.relPath)
+	case b64:
+		result.content = base64.StdEncoding.EncodeToString([]byte(target))
+	default:
+		result.content = target
+	}
+
+	return result
 }
 
 func main() {
 	// Customize usage information.
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Combine %s - %s\n\n", Version, Description)
-		fmt.Fprintf(os.Stderr, "Usage: %s [-o output_file] [-f extensions] [-fe excluded_extensions] [-e excluded_paths] [-p pattern] [-nocompact] [-checkformat] [directory]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-o output_file] [-f extensions] [-fe excluded_extensions] [-e excluded_patterns] [-i included_patterns] [-p pattern] [-nocompact] [-checkformat] [directory]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nCombine all text files in a directory (recursively) into a single output file with headers.\n\n")
 		fmt.Fprintf(os.Stderr, "Arguments:\n")
 		fmt.Fprintf(os.Stderr, "  directory     The directory to scan. If omitted, the current directory is used after confirmation.\n\n")
@@ -404,15 +1305,23 @@ func main() {
 	outName := flag.String("o", "combined_text.txt", "output file name (default \"combined_text.txt\")")
 	includeFormats := flag.String("f", "", "only include files with these extensions (comma-separated, e.g. \"py,txt,json\")")
 	excludeFormats := flag.String("fe", "", "exclude files with these extensions (comma-separated, e.g. \"exe,jpg,png\")")
-	excludePaths := flag.String("e", ".git", "exclude specific files or directories (comma-separated paths, e.g. \"node_modules,dist,temp.txt\") (default \".git\")")
+	excludePaths := flag.String("e", ".git", "exclude files/directories matching these gitignore-style patterns (comma-separated, e.g. \"node_modules,**/testdata/**,/dist\") (default \".git\")")
+	includePaths := flag.String("i", "", "only include files/directories matching these gitignore-style patterns (comma-separated, e.g. \"src/**/*.go\")")
 	pattern := flag.String("p", "", "only include files containing this text pattern")
+	jobsFlag := flag.Int("j", 0, "number of worker goroutines for file processing (0 = runtime.NumCPU())")
+	formatFlag := flag.String("format", "text", "output format: \"text\" (default), \"json\", or \"ndjson\"")
+	b64Flag := flag.Bool("b64", false, "base64-encode file content in -format json/ndjson (allows non-UTF-8 files through and skips the text-only filter)")
+	symlinksFlag := flag.String("symlinks", "skip", "how to handle symlinks: \"skip\" (default), \"follow\", or \"report\"")
+	symlinksExternalFlag := flag.Bool("symlinks-external", false, "with -symlinks follow, also follow symlinks that resolve outside the scanned directory")
 	checkFormatFlag := flag.Bool("checkformat", false, "check and display statistics about file formats in the directory")
-	noCompactFlag := flag.Bool("nocompact", false, "don't compress file content to single line (default is to compress)")
+	noCompactFlag := flag.Bool("nocompact", false, "don't compress file content to single line in -format text (default is to compress there); ignored if -transform is set or -format isn't text")
+	transformFlag := flag.String("transform", "", fmt.Sprintf("comma-separated transform pipeline applied to file bodies, in order (built-ins: %s); defaults to \"compact\" in -format text unless -nocompact is set, and to no transform in -format json/ndjson. In -format json/ndjson without -b64, \"size\"/\"sha256\" describe the transformed content (what's in \"content\"), not the file on disk", strings.Join(transformNames(), ", ")))
+	incrementalFlag := flag.Bool("incremental", false, "reuse unchanged files' bytes from a prior run's \"<output>.manifest.json\" sidecar instead of re-transforming them (requires -format text)")
 	versionFlag := flag.Bool("v", false, "display version information")
-	
+
 	// Parse flags
 	flag.Parse()
-	
+
 	// Handle version flag
 	if *versionFlag {
 		fmt.Printf("Combine %s\n", Version)
@@ -420,7 +1329,47 @@ func main() {
 		fmt.Printf("%s\n", Description)
 		os.Exit(0)
 	}
-	
+
+	switch *formatFlag {
+	case "text", "json", "ndjson":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid -format %q (must be \"text\", \"json\", or \"ndjson\")\n", *formatFlag)
+		os.Exit(1)
+	}
+
+	switch *symlinksFlag {
+	case "skip", "follow", "report":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid -symlinks %q (must be \"skip\", \"follow\", or \"report\")\n", *symlinksFlag)
+		os.Exit(1)
+	}
+
+	if *incrementalFlag && *formatFlag != "text" {
+		fmt.Fprintf(os.Stderr, "Error: -incremental requires -format text\n")
+		os.Exit(1)
+	}
+
+	// Resolve the transform pipeline: an explicit -transform list takes
+	// precedence over -nocompact, which only toggles the implicit
+	// single-transform "compact" pipeline that predates -transform. That
+	// implicit default only applies in -format text: for json/ndjson the
+	// "content" field is recorded alongside "size"/"sha256" of the original
+	// bytes, so silently compacting it by default would make the record
+	// internally inconsistent. Passing -transform explicitly still applies
+	// to json/ndjson, since that's an informed, opt-in choice.
+	var transforms []string
+	if *transformFlag != "" {
+		transforms = splitAndTrim(*transformFlag)
+		for _, name := range transforms {
+			if _, ok := Transforms[name]; !ok {
+				fmt.Fprintf(os.Stderr, "Error: unknown -transform %q (must be one of: %s)\n", name, strings.Join(transformNames(), ", "))
+				os.Exit(1)
+			}
+		}
+	} else if !*noCompactFlag && *formatFlag == "text" {
+		transforms = []string{"compact"}
+	}
+
 	// Determine the target directory.
 	directory := "."
 	if flag.NArg() > 0 {
@@ -438,23 +1387,16 @@ func main() {
 			excludeExts = strings.Split(*excludeFormats, ",")
 		}
 
-		// Process exclude paths
-		var excludedPaths []string
-		if *excludePaths != "" {
-			excludedPaths = strings.Split(*excludePaths, ",")
-			// Trim spaces
-			for i := range excludedPaths {
-				excludedPaths[i] = strings.TrimSpace(excludedPaths[i])
-			}
-		}
-		
+		excludePatterns := splitAndTrim(*excludePaths)
+		includePatterns := splitAndTrim(*includePaths)
+
 		// Get stats with filters applied
-		stats, err := getFormatStats(directory, includeExts, excludeExts, excludedPaths, *pattern)
+		stats, err := getFormatStats(directory, includeExts, excludeExts, excludePatterns, includePatterns, *pattern, *symlinksFlag, *symlinksExternalFlag)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error checking formats: %v\n", err)
 			os.Exit(1)
 		}
-		
+
 		// Create a sorted list of extensions for output
 		extensions := make([]string, 0, len(stats))
 		for ext := range stats {
@@ -468,9 +1410,9 @@ func main() {
 			// If counts are equal, sort alphabetically
 			return extensions[i] < extensions[j]
 		})
-		
+
 		// Print filter information if any filters are applied
-		if len(includeExts) > 0 || len(excludeExts) > 0 || len(excludedPaths) > 0 {
+		if len(includeExts) > 0 || len(excludeExts) > 0 || len(excludePatterns) > 0 || len(includePatterns) > 0 {
 			fmt.Println("Filters applied:")
 			if len(includeExts) > 0 {
 				fmt.Printf("- Including only: %s\n", strings.Join(includeExts, ", "))
@@ -478,12 +1420,15 @@ func main() {
 			if len(excludeExts) > 0 {
 				fmt.Printf("- Excluding extensions: %s\n", strings.Join(excludeExts, ", "))
 			}
-			if len(excludedPaths) > 0 {
-				fmt.Printf("- Excluding paths: %s\n", strings.Join(excludedPaths, ", "))
+			if len(excludePatterns) > 0 {
+				fmt.Printf("- Excluding paths: %s\n", strings.Join(excludePatterns, ", "))
+			}
+			if len(includePatterns) > 0 {
+				fmt.Printf("- Including paths: %s\n", strings.Join(includePatterns, ", "))
 			}
 			fmt.Println()
 		}
-		
+
 		// Display the results
 		fmt.Printf("File format statistics for %s:\n", directory)
 		fmt.Println("------------------------------------")
@@ -508,7 +1453,7 @@ func main() {
 			os.Exit(0)
 		}
 	}
-	
+
 	// Process include/exclude extensions
 	var includeExts, excludeExts []string
 	if *includeFormats != "" {
@@ -518,13 +1463,25 @@ func main() {
 		excludeExts = strings.Split(*excludeFormats, ",")
 	}
 
-	// Process exclude paths
-	var excludedPaths []string
-	if *excludePaths != "" {
-		excludedPaths = strings.Split(*excludePaths, ",")
-		// Trim spaces
-		for i := range excludedPaths {
-			excludedPaths[i] = strings.TrimSpace(excludedPaths[i])
+	excludePatterns := splitAndTrim(*excludePaths)
+	includePatterns := splitAndTrim(*includePaths)
+
+	// For -incremental, read the prior manifest and output bytes before
+	// they're truncated below. A missing or stale (different -p/-transform)
+	// manifest just means every file is treated as changed this run.
+	manifestPath := *outName + ".manifest.json"
+	oldManifest := make(map[string]manifestEntry)
+	var oldOutputBytes []byte
+	if *incrementalFlag {
+		if m, err := loadManifest(manifestPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring unreadable manifest %s: %v\n", manifestPath, err)
+		} else if m != nil && m.Signature.Pattern == *pattern && sameStrings(m.Signature.Transforms, transforms) {
+			if data, err := ioutil.ReadFile(*outName); err == nil {
+				oldOutputBytes = data
+				for _, e := range m.Entries {
+					oldManifest[e.Path] = e
+				}
+			}
 		}
 	}
 
@@ -543,195 +1500,394 @@ func main() {
 		os.Exit(1)
 	}
 
+	dirAbs, err := filepath.Abs(directory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error obtaining absolute path of directory: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Build and write the directory tree structure
-	dirTree, err := buildDirTree(directory, *outName, true, includeExts, excludeExts, excludedPaths, *pattern)
+	workers := resolveWorkerCount(*jobsFlag)
+
+	// isTextOnly must agree with processFileJob's relaxTextFilter, or a
+	// non-UTF-8 file let through by -b64 into "files" would be silently
+	// missing from "tree" in the same json/ndjson document.
+	isTextOnly := !(*b64Flag && *formatFlag != "text")
+	dirTree, err := buildDirTree(directory, *outName, isTextOnly, includeExts, excludeExts, excludePatterns, includePatterns, *pattern, workers, *symlinksFlag, *symlinksExternalFlag)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error building directory tree: %v\n", err)
 		os.Exit(1)
 	}
-	
-	// Convert tree to string
-	treeBuilder := &strings.Builder{}
-	printTreeToString(dirTree, "", false, treeBuilder)
-	treeStr := treeBuilder.String()
-	
-	// Write tree structure to output file
-	if _, err := outFile.WriteString(treeStr); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing directory structure: %v\n", err)
-		os.Exit(1)
+
+	filters := Filters{
+		IncludeExts:     includeExts,
+		ExcludeExts:     excludeExts,
+		ExcludePatterns: excludePatterns,
+		IncludePatterns: includePatterns,
+		Pattern:         *pattern,
 	}
-	
-	// Add filter information if filters are applied
-	if len(includeExts) > 0 || len(excludeExts) > 0 || len(excludedPaths) > 0 || *pattern != "" {
-		outFile.WriteString("\nFilters applied:\n")
-		if len(includeExts) > 0 {
-			outFile.WriteString(fmt.Sprintf("- Including only: %s\n", strings.Join(includeExts, ", ")))
+
+	if *formatFlag == "text" {
+		// Convert tree to string
+		treeBuilder := &strings.Builder{}
+		printTreeToString(dirTree, "", false, treeBuilder)
+		treeStr := treeBuilder.String()
+
+		// Write tree structure to output file
+		if _, err := outFile.WriteString(treeStr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing directory structure: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Add filter information if filters are applied
+		if len(includeExts) > 0 || len(excludeExts) > 0 || len(excludePatterns) > 0 || len(includePatterns) > 0 || *pattern != "" {
+			outFile.WriteString("\nFilters applied:\n")
+			if len(includeExts) > 0 {
+				outFile.WriteString(fmt.Sprintf("- Including only: %s\n", strings.Join(includeExts, ", ")))
+			}
+			if len(excludeExts) > 0 {
+				outFile.WriteString(fmt.Sprintf("- Excluding extensions: %s\n", strings.Join(excludeExts, ", ")))
+			}
+			if len(excludePatterns) > 0 {
+				outFile.WriteString(fmt.Sprintf("- Excluding paths: %s\n", strings.Join(excludePatterns, ", ")))
+			}
+			if len(includePatterns) > 0 {
+				outFile.WriteString(fmt.Sprintf("- Including paths: %s\n", strings.Join(includePatterns, ", ")))
+			}
+			if *pattern != "" {
+				outFile.WriteString(fmt.Sprintf("- Only files containing: \"%s\"\n", *pattern))
+			}
 		}
-		if len(excludeExts) > 0 {
-			outFile.WriteString(fmt.Sprintf("- Excluding extensions: %s\n", strings.Join(excludeExts, ", ")))
+
+		// Add separator between structure and content
+		if _, err := outFile.WriteString("\n" + strings.Repeat("-", 80) + "\n\n"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing separator: %v\n", err)
+			os.Exit(1)
 		}
-		if len(excludedPaths) > 0 {
-			outFile.WriteString(fmt.Sprintf("- Excluding paths: %s\n", strings.Join(excludedPaths, ", ")))
+	} else if *formatFlag == "ndjson" {
+		header := ndjsonHeader{Type: "header", Root: directory, Tree: dirTree, Filters: filters}
+		line, err := json.Marshal(header)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding ndjson header: %v\n", err)
+			os.Exit(1)
 		}
-		if *pattern != "" {
-			outFile.WriteString(fmt.Sprintf("- Only files containing: \"%s\"\n", *pattern))
+		if _, err := outFile.Write(append(line, '\n')); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing ndjson header: %v\n", err)
+			os.Exit(1)
 		}
 	}
-	
-	// Add separator between structure and content
-	if _, err := outFile.WriteString("\n" + strings.Repeat("-", 80) + "\n\n"); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing separator: %v\n", err)
+
+	// Everything written to outFile from here on is per-file content, so
+	// the current position is where -incremental's offsets start counting.
+	contentStart, err := outFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding output position: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Walk the directory recursively.
-	fileCount := 0
-	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	baseExclude := newPatternSet(excludePatterns)
+	include := newPatternSet(includePatterns)
+	excludeCache := make(map[string]*patternSet)
 
-		// Skip directories.
-		if info.IsDir() {
-			// Check if directory is in excluded paths
+	// Walk the directory recursively. The walk itself only enumerates
+	// candidates and assigns each a sequence number; the I/O-heavy work
+	// (text detection, pattern search, read, compaction) runs on a pool of
+	// worker goroutines, and a writer goroutine flushes their results back
+	// in walk order so the output is byte-identical to a sequential run.
+	jobs := make(chan fileJob, workers*2)
+	results := make(chan fileResult, workers*2)
+	walkErrCh := make(chan error, 1)
+
+	var workerWg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for job := range jobs {
+				results <- processFileJob(job, *pattern, transforms, *formatFlag, *b64Flag, *incrementalFlag, oldManifest, oldOutputBytes)
+			}
+		}()
+	}
+	go func() {
+		workerWg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		seq := 0
+		walkVisited := make(map[string]bool)
+
+		var visitWalk filepath.WalkFunc
+		visitWalk = func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip the output file itself.
+			currAbs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if currAbs == outAbs {
+				return nil
+			}
+
+			// Get the relative path for checking exclusions
 			relPath, err := filepath.Rel(directory, path)
 			if err != nil {
 				relPath = path
 			}
-			
-			if isExcludedPath(relPath, excludedPaths) {
-				return filepath.SkipDir
+
+			isReportSymlink := false
+			if info.Mode()&os.ModeSymlink != 0 {
+				action := resolveSymlink(dirAbs, path, *symlinksFlag, *symlinksExternalFlag, walkVisited)
+				if action.descendInto != "" {
+					return filepath.Walk(action.descendInto, func(subPath string, subInfo os.FileInfo, subErr error) error {
+						rel, relErr := filepath.Rel(action.descendInto, subPath)
+						mapped := path
+						if relErr == nil && rel != "." {
+							mapped = filepath.Join(path, rel)
+						}
+						return visitWalk(mapped, subInfo, subErr)
+					})
+				}
+				if !action.proceed {
+					return nil
+				}
+				isReportSymlink = *symlinksFlag == "report"
 			}
-			
-			return nil
-		}
 
-		// Skip the output file itself.
-		currAbs, err := filepath.Abs(path)
-		if err != nil {
-			return err
-		}
-		if currAbs == outAbs {
-			return nil
-		}
+			if info.IsDir() {
+				if path == directory {
+					return nil
+				}
+				decision := decidePath(excludeCache, directory, baseExclude, include, path, relPath, true)
+				if !decision.descend {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 
-		// Get the relative path for checking exclusions
-		relPath, err := filepath.Rel(directory, path)
-		if err != nil {
-			relPath = path
-		}
-		
-		// Skip excluded files
-		if isExcludedPath(relPath, excludedPaths) {
-			return nil
-		}
+			decision := decidePath(excludeCache, directory, baseExclude, include, path, relPath, false)
+			if !decision.included {
+				return nil
+			}
 
-		// Apply extension filters
-		ext := strings.TrimPrefix(filepath.Ext(path), ".")
-		ext = strings.ToLower(ext)
-		if len(includeExts) > 0 && !containsExt(includeExts, ext) {
-			return nil
-		}
-		if len(excludeExts) > 0 && containsExt(excludeExts, ext) {
-			return nil
-		}
+			// Apply extension filters
+			ext := strings.TrimPrefix(filepath.Ext(path), ".")
+			ext = strings.ToLower(ext)
+			if len(includeExts) > 0 && !containsExt(includeExts, ext) {
+				return nil
+			}
+			if len(excludeExts) > 0 && containsExt(excludeExts, ext) {
+				return nil
+			}
 
-		// Only process text files.
-		if !isTextFile(path) {
-			return nil
-		}
-		
-		// Check if file contains the specified pattern
-		if *pattern != "" && !fileContainsPattern(path, *pattern) {
+			jobs <- fileJob{seq: seq, path: path, relPath: relPath, info: info, symlinkReportOnly: isReportSymlink}
+			seq++
 			return nil
 		}
 
-		// Write the header.
-		header := fmt.Sprintf("== %s ==\n", relPath)
-		if _, err := outFile.WriteString(header); err != nil {
-			return err
-		}
+		walkErr := filepath.Walk(directory, visitWalk)
+		close(jobs)
+		walkErrCh <- walkErr
+	}()
 
-		// Read and write the file content.
-		content, err := ioutil.ReadFile(path)
-		if err != nil {
-			return err
-		}
+	// Reorder buffer: results can arrive out of sequence, so hold each one
+	// until every earlier sequence number has been flushed.
+	pending := make(map[int]fileResult)
+	next := 0
+	fileCount := 0
+	var firstErr error
+	var jsonFiles []FileRecord
+	var newManifest []manifestEntry
+	writeOffset := contentStart
+	for res := range results {
+		pending[res.seq] = res
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+				}
+				continue
+			}
+			if r.skip {
+				continue
+			}
 
-		// If compact flag is set, compress content to a single line
-		if !*noCompactFlag {
-			// Replace newlines with a special delimiter that helps preserve code structure
-			contentStr := string(content)
-			
-			// Normalize line endings
-			contentStr = strings.ReplaceAll(contentStr, "\r\n", "\n")
-			contentStr = strings.ReplaceAll(contentStr, "\r", "\n")
-			
-			// Process each line and add an indicator of indentation level
-			lines := strings.Split(contentStr, "\n")
-			var compressed strings.Builder
-			
-			for _, line := range lines {
-				// Count leading whitespace to preserve indentation info
-				indent := 0
-				for _, c := range line {
-					if c == ' ' {
-						indent++
-					} else if c == '\t' {
-						indent += 4 // Treat tab as 4 spaces
-					} else {
-						break
+			record := FileRecord{
+				Path:    r.relPath,
+				Size:    r.size,
+				SHA256:  r.sha256,
+				Mode:    r.mode,
+				ModTime: r.modTime,
+				Content: r.content,
+			}
+
+			switch *formatFlag {
+			case "text":
+				entryStart := writeOffset
+				if r.spliced != nil {
+					n, err := outFile.Write(r.spliced)
+					if err != nil {
+						if firstErr == nil {
+							firstErr = err
+						}
+						continue
+					}
+					writeOffset += int64(n)
+				} else {
+					n, err := outFile.WriteString(r.header)
+					if err != nil {
+						if firstErr == nil {
+							firstErr = err
+						}
+						continue
+					}
+					writeOffset += int64(n)
+					n, err = outFile.Write(r.body)
+					if err != nil {
+						if firstErr == nil {
+							firstErr = err
+						}
+						continue
 					}
+					writeOffset += int64(n)
+					n, err = outFile.WriteString("\n\n")
+					if err != nil {
+						if firstErr == nil {
+							firstErr = err
+						}
+						continue
+					}
+					writeOffset += int64(n)
 				}
-				
-				// Trim the line
-				trimmedLine := strings.TrimSpace(line)
-				if trimmedLine == "" {
-					continue // Skip empty lines
+				if *incrementalFlag {
+					newManifest = append(newManifest, manifestEntry{
+						Path:    r.relPath,
+						Size:    r.size,
+						ModTime: r.modTime,
+						SHA256:  r.sha256,
+						Offset:  entryStart,
+						Length:  writeOffset - entryStart,
+					})
 				}
-				
-				// Add a separator between lines, but not before the first line
-				if compressed.Len() > 0 {
-					compressed.WriteString(" ")
+			case "ndjson":
+				line, err := json.Marshal(ndjsonFileRecord{Type: "file", FileRecord: record})
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
 				}
-				
-				// Add indentation spaces for readability, without special symbols
-				if indent > 0 {
-					// Use a space followed by additional spaces for each level of indentation
-					compressed.WriteString(strings.Repeat(" ", 1+(indent/4)))
+				if _, err := outFile.Write(append(line, '\n')); err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
 				}
-				
-				// Add the line content
-				compressed.WriteString(trimmedLine)
+			case "json":
+				jsonFiles = append(jsonFiles, record)
 			}
-			
-			// Write the compressed content
-			if _, err := outFile.WriteString(compressed.String()); err != nil {
-				return err
-			}
-		} else {
-			// Write the original content
-			if _, err := outFile.Write(content); err != nil {
-				return err
-			}
-		}
 
-		// Add spacing between file contents.
-		if _, err := outFile.WriteString("\n\n"); err != nil {
-			return err
+			fmt.Println(r.relPath)
+			fileCount++
 		}
+	}
 
-		// Output the processed file name to the console.
-		fmt.Println(relPath)
-		fileCount++
-		return nil
-	})
+	if walkErr := <-walkErrCh; walkErr != nil && firstErr == nil {
+		firstErr = walkErr
+	}
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error processing directory: %v\n", err)
+	if firstErr != nil {
+		fmt.Fprintf(os.Stderr, "Error processing directory: %v\n", firstErr)
 		os.Exit(1)
 	}
 
+	if *formatFlag == "json" {
+		doc := jsonDocument{Root: directory, Tree: dirTree, Filters: filters, Files: jsonFiles}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding json output: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := outFile.Write(data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing json output: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *incrementalFlag {
+		m := manifest{
+			Signature: manifestSignature{Pattern: *pattern, Transforms: transforms},
+			Entries:   newManifest,
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(manifestPath, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing manifest %s: %v\n", manifestPath, err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Println(*outName)
 	fmt.Printf("\nMerging complete. Output file: %s (%d files processed)\n", *outName, fileCount)
 }
+
+// loadManifest reads and parses a sidecar manifest written by a previous
+// `-incremental` run. A missing file is not an error: it returns (nil, nil)
+// so the caller treats it as "no prior run to diff against".
+func loadManifest(path string) (*manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// sameStrings reports whether a and b contain the same strings in the same
+// order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitAndTrim splits a comma-separated flag value into trimmed, non-empty parts.
+func splitAndTrim(value string) []string {
+	if value == "" {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	result := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
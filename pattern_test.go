@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// segs splits a "/"-joined test path the same way patternSet.match does.
+func segs(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func TestDoubleStarMatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern []string
+		path    string
+		want    bool
+	}{
+		{"literal match", []string{"foo.go"}, "foo.go", true},
+		{"literal mismatch", []string{"foo.go"}, "bar.go", false},
+		{"leading doublestar matches zero segments", []string{"**", "foo.go"}, "foo.go", true},
+		{"leading doublestar matches nested", []string{"**", "foo.go"}, "a/b/foo.go", true},
+		{"trailing doublestar matches everything under", []string{"src", "**"}, "src/a/b.go", true},
+		{"trailing doublestar requires prefix", []string{"src", "**"}, "other/a/b.go", false},
+		{"mid doublestar spans multiple segments", []string{"a", "**", "z.go"}, "a/b/c/z.go", true},
+		{"mid doublestar spans zero segments", []string{"a", "**", "z.go"}, "a/z.go", true},
+		{"single star stays within a segment", []string{"*.go"}, "a/b.go", false},
+		{"single star matches within one segment", []string{"*.go"}, "b.go", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := doubleStarMatch(c.pattern, segs(c.path)); got != c.want {
+				t.Errorf("doubleStarMatch(%v, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDoubleStarPartialMatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern []string
+		path    string
+		want    bool
+	}{
+		{"ancestor of a deep include", []string{"src", "**", "*.go"}, "src", true},
+		{"ancestor one level down", []string{"src", "**", "*.go"}, "src/pkg", true},
+		{"unrelated directory", []string{"src", "**", "*.go"}, "testdata", false},
+		{"doublestar-only pattern matches any prefix", []string{"**", "*.go"}, "anything/here", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := doubleStarPartialMatch(c.pattern, segs(c.path)); got != c.want {
+				t.Errorf("doubleStarPartialMatch(%v, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+			}
+		})
+	}
+}
+
+func TestPatternSetMatchNegationOrder(t *testing.T) {
+	// Later patterns override earlier ones, gitignore-style: the build
+	// artifact is excluded, but an explicit "!keep.log" re-includes one file.
+	ps := newPatternSet([]string{"*.log", "!keep.log"})
+
+	if !ps.match("build.log", false) {
+		t.Error("build.log should be excluded by *.log")
+	}
+	if ps.match("keep.log", false) {
+		t.Error("keep.log should be re-included by the later !keep.log negation")
+	}
+}
+
+func TestPatternSetMatchReorderChangesResult(t *testing.T) {
+	// Swapping the order flips the outcome, proving match() isn't just
+	// "excluded if any pattern matches" but genuinely last-match-wins.
+	ps := newPatternSet([]string{"!keep.log", "*.log"})
+
+	if !ps.match("keep.log", false) {
+		t.Error("keep.log should be excluded again once *.log comes after the negation")
+	}
+}
+
+func TestPatternSetMatchDirOnly(t *testing.T) {
+	ps := newPatternSet([]string{"build/"})
+
+	if !ps.match("build", true) {
+		t.Error("trailing-slash pattern should match a directory named build")
+	}
+	if ps.match("build", false) {
+		t.Error("trailing-slash pattern should not match a file named build")
+	}
+}
+
+func TestPatternSetMatchAnchored(t *testing.T) {
+	ps := newPatternSet([]string{"/vendor"})
+
+	if !ps.match("vendor", true) {
+		t.Error("/vendor should match the root-level vendor directory")
+	}
+	if ps.match("src/vendor", true) {
+		t.Error("/vendor should not match a nested vendor directory")
+	}
+}
+
+func TestPatternSetMatchPartialDescendsTowardInclude(t *testing.T) {
+	// An include pattern like "src/**/*.go" must not prune "src" from the
+	// walk even though "src" itself doesn't match the pattern.
+	include := newPatternSet([]string{"src/**/*.go"})
+
+	if !include.matchPartial("src") {
+		t.Error("matchPartial(\"src\") should be true so the walk still descends into src")
+	}
+	if include.matchPartial("testdata") {
+		t.Error("matchPartial(\"testdata\") should be false: no pattern can match under it")
+	}
+	if include.match("src", true) {
+		t.Error("match(\"src\", true) should be false: the directory itself isn't an include match")
+	}
+}
+
+func TestPatternSetMatchPartialIgnoresNegation(t *testing.T) {
+	// matchPartial only considers positive patterns, per its doc comment:
+	// negations can't be the reason to descend into a directory.
+	ps := newPatternSet([]string{"!build/keep/**"})
+
+	if ps.matchPartial("build") {
+		t.Error("matchPartial should ignore a negation-only pattern set")
+	}
+}
+
+// TestExcludeSetForDirComposesCombineIgnore checks that a .combineignore in
+// a subdirectory extends (rather than replaces) the patterns inherited from
+// its ancestors, the way .gitignore composes down a tree.
+func TestExcludeSetForDirComposesCombineIgnore(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, combineIgnoreFile), []byte("*.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	base := newPatternSet([]string{"*.log"})
+	cache := make(map[string]*patternSet)
+
+	rootSet := excludeSetForDir(cache, root, root, base)
+	if !rootSet.match("a.log", false) {
+		t.Error("root's set should still carry the base *.log pattern")
+	}
+	if rootSet.match("a.tmp", false) {
+		t.Error("root's set should not pick up sub's .combineignore")
+	}
+
+	subSet := excludeSetForDir(cache, root, sub, base)
+	if !subSet.match("a.log", false) {
+		t.Error("sub's set should still carry the inherited *.log pattern")
+	}
+	if !subSet.match("a.tmp", false) {
+		t.Error("sub's set should also exclude *.tmp from its own .combineignore")
+	}
+}